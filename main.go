@@ -2,11 +2,14 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"encoding/xml"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,14 +19,16 @@ const (
 )
 
 type Test struct {
-	Start    string
-	Name     string
-	Output   string
-	Details  []string
-	Duration time.Duration
-	Status   string
-	Race     bool
-	Suite    bool
+	Start     string
+	StartTime time.Time
+	Name      string
+	Package   string
+	Output    string
+	Details   []string
+	Duration  time.Duration
+	Status    string
+	Race      bool
+	Suite     bool
 }
 
 var (
@@ -31,15 +36,41 @@ var (
 	output = os.Stdout
 
 	additionalTestName = ""
+	jsonInput          = false
+	outputFormat       = "teamcity"
+	timestampInput     = false
 
 	run  = regexp.MustCompile("^=== RUN\\s+([a-zA-Z_]\\S*)")
 	end  = regexp.MustCompile("^(\\s*)--- (PASS|SKIP|FAIL):\\s+([a-zA-Z_]\\S*) \\((-?[\\.\\ds]+)\\)")
 	pkg  = regexp.MustCompile("^(ok|PASS|FAIL|exit status|Found)")
 	race = regexp.MustCompile("^WARNING: DATA RACE")
+
+	// gocheck (gopkg.in/check.v1) output, e.g.:
+	//   START: foo_test.go:42: FooSuite.TestBar
+	//   PASS: foo_test.go:42: FooSuite.TestBar	0.001s
+	gocheckStart = regexp.MustCompile("^START: \\S+:\\d+: ([a-zA-Z_]\\w*)\\.([a-zA-Z_]\\w*)\\s*$")
+	gocheckEnd   = regexp.MustCompile("^(PASS|FAIL|SKIP|PANIC|MISS): \\S+:\\d+: ([a-zA-Z_]\\w*)\\.([a-zA-Z_]\\w*)\\s+(\\d+(?:\\.\\d+)?s)")
+
+	// `go test ./...` package-result lines that never produce a `--- PASS`
+	// or `--- FAIL` for any individual test.
+	buildFailed = regexp.MustCompile("^FAIL\\s+(\\S+)\\s+\\[(build failed|setup failed)\\]")
+	noTestFiles = regexp.MustCompile("^\\?\\s+(\\S+)\\s+\\[no test files\\]")
+
+	// e.g. "BenchmarkFoo-8   	 2000000	       716 ns/op	      32 B/op	       1 allocs/op"
+	bench = regexp.MustCompile("^(Benchmark\\S*)\\s+(\\d+)\\s+([\\d.]+)\\s+ns/op(?:\\s+([\\d.]+)\\s+MB/s)?(?:\\s+(\\d+)\\s+B/op)?(?:\\s+(\\d+)\\s+allocs/op)?")
+
+	// A leading timestamp (e.g. piped through `ts '%Y-%m-%dT%H:%M:%.S'`) giving
+	// the real time a line was produced, so timestamps survive buffering.
+	// Only consulted when -timestamp is passed - test output routinely starts
+	// with ISO-ish timestamps of its own (log lines), which this must not eat.
+	timestampPrefix = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?)\s+`)
 )
 
 func init() {
 	flag.StringVar(&additionalTestName, "name", "", "Add prefix to test name")
+	flag.BoolVar(&jsonInput, "json", false, "Consume `go test -json` (test2json) output instead of plain go test output")
+	flag.StringVar(&outputFormat, "format", "teamcity", "Output format: teamcity or junit")
+	flag.BoolVar(&timestampInput, "timestamp", false, "Input lines are prefixed with a real timestamp (e.g. piped through `ts '%Y-%m-%dT%H:%M:%.S'`); use it instead of time.Now()")
 }
 
 func escapeLines(lines []string) string {
@@ -56,44 +87,211 @@ func escape(s string) string {
 	return s
 }
 
-func getNow() string {
-	return time.Now().Format(TEAMCITY_TIMESTAMP_FORMAT)
+// stripTimestamp strips a leading timestamp prefix from line, if present,
+// and returns the time it carried alongside the remaining line. If no
+// prefix is found, it returns the zero time and the line unchanged.
+func stripTimestamp(line string) (time.Time, string) {
+	m := timestampPrefix.FindStringSubmatch(line)
+	if m == nil {
+		return time.Time{}, line
+	}
+	ts := strings.Replace(m[1], " ", "T", 1)
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, ts); err == nil {
+			return t, line[len(m[0]):]
+		}
+	}
+	return time.Time{}, line
+}
+
+// finishTimestamp is start + duration rather than time.Now(), so that a
+// test's reported timestamp reflects when it actually ran even if this
+// tool only gets around to reporting it (flushing on the next marker line)
+// well after the fact.
+func finishTimestamp(test *Test) string {
+	start := test.StartTime
+	if start.IsZero() {
+		start = time.Now()
+	}
+	return start.Add(test.Duration).Format(TEAMCITY_TIMESTAMP_FORMAT)
+}
+
+// flowAttr renders a ` flowId='...'` attribute, or the empty string when
+// flowID is empty so callers outside of -json mode don't emit one at all.
+func flowAttr(flowID string) string {
+	if flowID == "" {
+		return ""
+	}
+	return fmt.Sprintf(" flowId='%s'", escape(flowID))
+}
+
+// Reporter abstracts where suite/test results go, so the same processReader
+// / processJSON parsing drives either TeamCity service messages or a JUnit
+// XML report. BenchStat is TeamCity-specific (JUnit has no equivalent) and
+// is a no-op on reporters that don't support it.
+type Reporter interface {
+	SuiteStart(name string)
+	SuiteEnd(name string)
+	TestResult(test *Test, flowID string)
+	BenchStat(name string, value string, unit string)
+	Raw(s string)
+	Finalize()
+}
+
+type teamcityReporter struct {
+	w io.Writer
+}
+
+func (r *teamcityReporter) Raw(s string) {
+	fmt.Fprint(r.w, s)
+}
+
+func (r *teamcityReporter) SuiteStart(name string) {
+	fmt.Fprintf(r.w, "##teamcity[testSuiteStarted name='%s']\n", escape(name))
 }
 
-func outputTest(w io.Writer, test *Test) {
-	now := getNow()
+func (r *teamcityReporter) SuiteEnd(name string) {
+	fmt.Fprintf(r.w, "##teamcity[testSuiteFinished name='%s']\n", escape(name))
+}
+
+func (r *teamcityReporter) TestResult(test *Test, flowID string) {
+	now := finishTimestamp(test)
 	testName := escape(additionalTestName + test.Name)
-	fmt.Fprintf(w, "##teamcity[testStarted timestamp='%s' name='%s' captureStandardOutput='true']\n", test.Start, testName)
-	fmt.Fprint(w, test.Output)
+	flow := flowAttr(flowID)
+	fmt.Fprintf(r.w, "##teamcity[testStarted timestamp='%s' name='%s' captureStandardOutput='true'%s]\n", test.Start, testName, flow)
+	if flowID == "" {
+		fmt.Fprint(r.w, test.Output)
+	} else if test.Output != "" {
+		fmt.Fprintf(r.w, "##teamcity[testStdOut name='%s' out='%s' flowId='%s']\n", testName, escape(test.Output), escape(flowID))
+	}
 	if test.Status == "SKIP" {
-		fmt.Fprintf(w, "##teamcity[testIgnored timestamp='%s' name='%s']\n", now, testName)
+		fmt.Fprintf(r.w, "##teamcity[testIgnored timestamp='%s' name='%s'%s]\n", now, testName, flow)
 	} else {
 		if test.Race {
-			fmt.Fprintf(w, "##teamcity[testFailed timestamp='%s' name='%s' message='Race detected!' details='%s']\n",
-				now, testName, escapeLines(test.Details))
+			fmt.Fprintf(r.w, "##teamcity[testFailed timestamp='%s' name='%s' message='Race detected!' details='%s'%s]\n",
+				now, testName, escapeLines(test.Details), flow)
 		} else {
 			switch test.Status {
 			case "FAIL":
-				fmt.Fprintf(w, "##teamcity[testFailed timestamp='%s' name='%s' details='%s']\n",
-					now, testName, escapeLines(test.Details))
+				fmt.Fprintf(r.w, "##teamcity[testFailed timestamp='%s' name='%s' details='%s'%s]\n",
+					now, testName, escapeLines(test.Details), flow)
 			case "PASS":
 				// ignore
 			default:
-				fmt.Fprintf(w, "##teamcity[testFailed timestamp='%s' name='%s' message='Test ended in panic.' details='%s']\n",
-					now, testName, escapeLines(test.Details))
+				fmt.Fprintf(r.w, "##teamcity[testFailed timestamp='%s' name='%s' message='Test ended in panic.' details='%s'%s]\n",
+					now, testName, escapeLines(test.Details), flow)
 			}
 		}
-		fmt.Fprintf(w, "##teamcity[testFinished timestamp='%s' name='%s' duration='%d']\n",
-			now, testName, test.Duration/time.Millisecond)
+		fmt.Fprintf(r.w, "##teamcity[testFinished timestamp='%s' name='%s' duration='%d'%s]\n",
+			now, testName, test.Duration/time.Millisecond, flow)
+	}
+}
+
+func (r *teamcityReporter) BenchStat(name, value, unit string) {
+	fmt.Fprintf(r.w, "##teamcity[buildStatisticValue key='%s.%s' value='%s']\n", escape(name), unit, value)
+}
+
+func (r *teamcityReporter) Finalize() {}
+
+// junitSuite accumulates the testcases of one JUnit <testsuite>.
+type junitSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Details string `xml:",chardata"`
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+// junitReporter buffers suites/tests in memory and writes them out as a
+// single JUnit XML document on Finalize, for CI systems (Jenkins, GitLab,
+// ...) that don't speak TeamCity service messages.
+type junitReporter struct {
+	w      io.Writer
+	suites []*junitSuite
+	stack  []*junitSuite
+}
+
+func (r *junitReporter) Raw(string) {
+	// No place for free-form preamble/compiler text in the JUnit schema.
+}
+
+func (r *junitReporter) current() *junitSuite {
+	if len(r.stack) == 0 {
+		r.SuiteStart("go test")
 	}
+	return r.stack[len(r.stack)-1]
 }
 
-func startSuite(w io.Writer, name string) {
-	fmt.Fprintf(w, "##teamcity[testSuiteStarted name='%s']\n", escape(name))
+func (r *junitReporter) SuiteStart(name string) {
+	s := &junitSuite{Name: name}
+	r.suites = append(r.suites, s)
+	r.stack = append(r.stack, s)
 }
 
-func finishSuite(w io.Writer, name string) {
-	fmt.Fprintf(w, "##teamcity[testSuiteFinished name='%s']\n", escape(name))
+func (r *junitReporter) SuiteEnd(name string) {
+	if len(r.stack) > 0 {
+		r.stack = r.stack[:len(r.stack)-1]
+	}
+}
+
+func (r *junitReporter) TestResult(test *Test, flowID string) {
+	s := r.current()
+	tc := junitTestCase{
+		Name:      additionalTestName + test.Name,
+		Time:      test.Duration.Seconds(),
+		SystemOut: test.Output,
+	}
+	switch {
+	case test.Status == "SKIP":
+		tc.Skipped = &struct{}{}
+		s.Skipped++
+	case test.Race:
+		tc.Failure = &junitFailure{Message: "Race detected!", Details: strings.Join(test.Details, "\n")}
+		s.Failures++
+	case test.Status == "FAIL":
+		tc.Failure = &junitFailure{Message: "Test failed", Details: strings.Join(test.Details, "\n")}
+		s.Failures++
+	case test.Status != "PASS":
+		tc.Failure = &junitFailure{Message: "Test ended in panic.", Details: strings.Join(test.Details, "\n")}
+		s.Failures++
+	}
+	s.Tests++
+	s.Time += tc.Time
+	s.Cases = append(s.Cases, tc)
+}
+
+func (r *junitReporter) BenchStat(name, value, unit string) {}
+
+func (r *junitReporter) Finalize() {
+	doc := junitSuites{}
+	for _, s := range r.suites {
+		doc.Suites = append(doc.Suites, *s)
+	}
+	fmt.Fprint(r.w, xml.Header)
+	enc := xml.NewEncoder(r.w)
+	enc.Indent("", "  ")
+	enc.Encode(doc)
+	fmt.Fprintln(r.w)
 }
 
 func suite(name string) string {
@@ -103,14 +301,44 @@ func suite(name string) string {
 	return ""
 }
 
-func processReader(r *bufio.Reader, w io.Writer) {
+// reportBench reports a `go test -bench` result line (the submatch of the
+// `bench` regexp: name, iterations, ns/op, optional MB/s, B/op, allocs/op)
+// as build statistics plus a passing test, so it shows trends across builds
+// and appears in the test tab with duration = iters * ns/op.
+func reportBench(rep Reporter, match []string) {
+	name := additionalTestName + match[1]
+	rep.BenchStat(name, match[3], "ns/op")
+	if match[4] != "" {
+		rep.BenchStat(name, match[4], "MB/s")
+	}
+	if match[5] != "" {
+		rep.BenchStat(name, match[5], "B/op")
+	}
+	if match[6] != "" {
+		rep.BenchStat(name, match[6], "allocs/op")
+	}
+
+	iters, _ := strconv.ParseInt(match[2], 10, 64)
+	nsPerOp, _ := strconv.ParseFloat(match[3], 64)
+	now := time.Now()
+	rep.TestResult(&Test{
+		Name:      match[1],
+		Start:     now.Format(TEAMCITY_TIMESTAMP_FORMAT),
+		StartTime: now,
+		Status:    "PASS",
+		Duration:  time.Duration(float64(iters) * nsPerOp),
+	}, "")
+}
+
+func processReader(r *bufio.Reader, rep Reporter) {
 	tests := map[string]*Test{}
 	suites := []string{}
 	var test *Test
-	newTest := func(name string) *Test {
+	newTest := func(name string, at time.Time) *Test {
 		t := &Test{
-			Name:  name,
-			Start: getNow(),
+			Name:      name,
+			Start:     at.Format(TEAMCITY_TIMESTAMP_FORMAT),
+			StartTime: at,
 		}
 		tests[t.Name] = t
 		for n := suite(name); n != ""; n = suite(n) {
@@ -122,44 +350,122 @@ func processReader(r *bufio.Reader, w io.Writer) {
 	}
 	var final string
 	prefix := "\t"
+	gcSuite := ""
+	// pending buffers raw output seen since the last package boundary, so a
+	// later "[build failed]"/"[setup failed]" marker can report it as the
+	// compiler/vet output that caused the failure.
+	var pending []string
 	for {
 		line, err := r.ReadString('\n')
 		if err != nil {
 			break
 		}
 
+		var lineTime time.Time
+		if timestampInput {
+			lineTime, line = stripTimestamp(line)
+		}
+		if lineTime.IsZero() {
+			lineTime = time.Now()
+		}
+
+		gcStartOut := gocheckStart.FindStringSubmatch(line)
+		gcEndOut := gocheckEnd.FindStringSubmatch(line)
 		runOut := run.FindStringSubmatch(line)
 		endOut := end.FindStringSubmatch(line)
-		pkgOut := pkg.FindStringSubmatch(line)
+		buildFailedOut := buildFailed.FindStringSubmatch(line)
+		noTestFilesOut := noTestFiles.FindStringSubmatch(line)
+		// A gocheck end line such as "PASS: foo_test.go:1: S.T" also matches
+		// the gotest package-result regex (it starts with "PASS"/"FAIL"), so
+		// only consult pkg once we know gocheck/build-result didn't already
+		// claim the line.
+		var pkgOut []string
+		if gcStartOut == nil && gcEndOut == nil && buildFailedOut == nil && noTestFilesOut == nil {
+			pkgOut = pkg.FindStringSubmatch(line)
+		}
+
+		if buildFailedOut != nil {
+			rep.TestResult(&Test{
+				Name:      buildFailedOut[1],
+				Start:     lineTime.Format(TEAMCITY_TIMESTAMP_FORMAT),
+				StartTime: lineTime,
+				Status:    "FAIL",
+				Details:   pending,
+			}, "")
+			pending = nil
+			continue
+		}
+		if noTestFilesOut != nil {
+			rep.TestResult(&Test{
+				Name:      noTestFilesOut[1],
+				Start:     lineTime.Format(TEAMCITY_TIMESTAMP_FORMAT),
+				StartTime: lineTime,
+				Status:    "SKIP",
+			}, "")
+			pending = nil
+			continue
+		}
+
+		if benchOut := bench.FindStringSubmatch(line); benchOut != nil {
+			reportBench(rep, benchOut)
+			continue
+		}
 
-		if test != nil && test.Status != "" && (runOut != nil || endOut != nil || pkgOut != nil) {
+		if test != nil && test.Status != "" && (runOut != nil || endOut != nil || pkgOut != nil || gcStartOut != nil || gcEndOut != nil) {
 			for j := len(suites) - 1; j >= 0; j-- {
 				if !strings.HasPrefix(test.Name, suites[j]) {
-					finishSuite(w, suites[j])
+					rep.SuiteEnd(suites[j])
 					suites = suites[:j]
 				}
 			}
 			if test.Suite {
-				startSuite(w, test.Name)
+				rep.SuiteStart(test.Name)
 				suites = append(suites, test.Name)
 			}
-			outputTest(w, test)
+			rep.TestResult(test, "")
 			delete(tests, test.Name)
 			test = nil
 		}
 
-		if runOut != nil {
-			test = newTest(runOut[1])
+		if gcStartOut != nil {
+			if gcSuite != "" && gcSuite != gcStartOut[1] {
+				rep.SuiteEnd(gcSuite)
+				gcSuite = ""
+			}
+			if gcSuite == "" {
+				gcSuite = gcStartOut[1]
+				rep.SuiteStart(gcSuite)
+			}
+			test = newTest(gcStartOut[2], lineTime)
+		} else if gcEndOut != nil {
+			test = tests[gcEndOut[3]]
+			if test == nil {
+				test = newTest(gcEndOut[3], lineTime)
+			}
+			switch gcEndOut[1] {
+			case "PANIC", "MISS":
+				test.Status = "FAIL"
+			default:
+				test.Status = gcEndOut[1]
+			}
+			if test.Status == "FAIL" {
+				test.Details = strings.Split(strings.TrimRight(test.Output, "\n"), "\n")
+			}
+			test.Duration, _ = time.ParseDuration(gcEndOut[4])
+		} else if runOut != nil {
+			pending = nil
+			test = newTest(runOut[1], lineTime)
 		} else if endOut != nil {
 			test = tests[endOut[3]]
 			if test == nil {
-				test = newTest(endOut[3])
+				test = newTest(endOut[3], lineTime)
 			}
 			prefix = endOut[1] + "\t"
 			test.Status = endOut[2]
 			test.Duration, _ = time.ParseDuration(endOut[4])
 		} else if pkgOut != nil {
 			final += line
+			pending = nil
 		} else if test != nil && race.MatchString(line) {
 			test.Race = true
 		} else if test != nil && test.Status != "" && strings.HasPrefix(line, prefix) {
@@ -169,21 +475,142 @@ func processReader(r *bufio.Reader, w io.Writer) {
 		} else if test != nil {
 			test.Output += line
 		} else {
-			fmt.Fprint(w, line)
+			rep.Raw(line)
+			pending = append(pending, strings.TrimRight(line, "\n"))
 		}
 	}
 	if test != nil {
-		outputTest(w, test)
+		rep.TestResult(test, "")
 		delete(tests, test.Name)
 	}
 	for j := len(suites) - 1; j >= 0; j-- {
-		finishSuite(w, suites[j])
+		rep.SuiteEnd(suites[j])
+	}
+	if gcSuite != "" {
+		rep.SuiteEnd(gcSuite)
 	}
 	for _, t := range tests {
-		outputTest(w, t)
+		rep.TestResult(t, "")
+	}
+
+	rep.Raw(final)
+}
+
+// testEvent mirrors the event shape produced by `go test -json`
+// (cmd/internal/test2json): one JSON object per line.
+type testEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+func jsonKey(pkg, test string) string {
+	return pkg + "/" + test
+}
+
+// processJSON consumes the newline-delimited JSON event stream produced by
+// `go test -json` and drives the same Test/suite machinery as processReader,
+// but off real event timestamps instead of time.Now(). Tests and package
+// suites are tagged with a flowId so parallel runs stay correctly grouped
+// in the TeamCity UI.
+func processJSON(r *bufio.Reader, rep Reporter) {
+	tests := map[string]*Test{}
+	suiteOpen := map[string]bool{}
+
+	finish := func(ev testEvent, t *Test) {
+		t.Duration = time.Duration(ev.Elapsed * float64(time.Second))
+		rep.TestResult(t, jsonKey(ev.Package, ev.Test))
+		delete(tests, jsonKey(ev.Package, ev.Test))
 	}
 
-	fmt.Fprint(w, final)
+	for {
+		line, err := r.ReadString('\n')
+		if line == "" && err != nil {
+			break
+		}
+
+		// go test -json still prints a failing package's build output (e.g.
+		// "# pkg", compiler errors, "FAIL pkg [build failed]") as plain text
+		// ahead of the JSON stream for the packages after it. A single
+		// json.Decoder over the whole stream would choke on that text and
+		// give up on everything that follows it, so each line is decoded
+		// independently: a line that isn't a JSON event is forwarded as-is
+		// instead of losing the rest of the run.
+		var ev testEvent
+		if json.Unmarshal([]byte(line), &ev) != nil {
+			rep.Raw(line)
+			if err != nil {
+				break
+			}
+			continue
+		}
+
+		if ev.Package != "" && !suiteOpen[ev.Package] {
+			suiteOpen[ev.Package] = true
+			rep.SuiteStart(ev.Package)
+		}
+
+		if ev.Test == "" {
+			// Package-level event: only pass/fail/skip close out the suite.
+			switch ev.Action {
+			case "pass", "fail", "skip":
+				rep.SuiteEnd(ev.Package)
+				delete(suiteOpen, ev.Package)
+			}
+			continue
+		}
+
+		k := jsonKey(ev.Package, ev.Test)
+		switch ev.Action {
+		case "run":
+			tests[k] = &Test{
+				Name:      ev.Test,
+				Package:   ev.Package,
+				Start:     ev.Time.Format(TEAMCITY_TIMESTAMP_FORMAT),
+				StartTime: ev.Time,
+			}
+		case "pause", "cont":
+			// Tolerated: parallel tests may be paused/resumed between run and
+			// pass/fail/skip without affecting reported duration.
+		case "output":
+			if t := tests[k]; t != nil {
+				t.Output += ev.Output
+			}
+		case "pass":
+			if t := tests[k]; t != nil {
+				t.Status = "PASS"
+				finish(ev, t)
+			}
+		case "skip":
+			if t := tests[k]; t != nil {
+				t.Status = "SKIP"
+				finish(ev, t)
+			}
+		case "fail":
+			if t := tests[k]; t != nil {
+				t.Status = "FAIL"
+				finish(ev, t)
+			}
+		}
+	}
+
+	// Any entry still in tests here never saw a pass/fail/skip action - e.g.
+	// `go test -json -bench=.` emits `run` and `output` events for a
+	// benchmark but no terminating action - and is dropped rather than
+	// reported, since there's no real result to report.
+	for pkgName := range suiteOpen {
+		rep.SuiteEnd(pkgName)
+	}
+}
+
+func newReporter(w io.Writer, format string) Reporter {
+	if format == "junit" {
+		return &junitReporter{w: w}
+	}
+	return &teamcityReporter{w: w}
 }
 
 func main() {
@@ -194,6 +621,13 @@ func main() {
 	}
 
 	reader := bufio.NewReader(input)
+	rep := newReporter(output, outputFormat)
+
+	if jsonInput {
+		processJSON(reader, rep)
+	} else {
+		processReader(reader, rep)
+	}
 
-	processReader(reader, output)
+	rep.Finalize()
 }
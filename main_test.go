@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runReader(t *testing.T, input string, useTimestamp bool) string {
+	t.Helper()
+	old := timestampInput
+	timestampInput = useTimestamp
+	defer func() { timestampInput = old }()
+
+	var buf bytes.Buffer
+	processReader(bufio.NewReader(strings.NewReader(input)), &teamcityReporter{w: &buf})
+	return buf.String()
+}
+
+func runJSON(t *testing.T, input string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	processJSON(bufio.NewReader(strings.NewReader(input)), &teamcityReporter{w: &buf})
+	return buf.String()
+}
+
+func TestProcessReaderGotest(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		wantIn []string
+		wantNo []string
+	}{
+		{
+			name: "pass",
+			input: "=== RUN   TestFoo\n" +
+				"--- PASS: TestFoo (0.01s)\n" +
+				"ok  \tpkg/foo\t0.010s\n",
+			wantIn: []string{"testStarted", "name='TestFoo'", "testFinished"},
+			wantNo: []string{"testFailed"},
+		},
+		{
+			name: "fail with details",
+			input: "=== RUN   TestBar\n" +
+				"--- FAIL: TestBar (0.02s)\n" +
+				"\tsomething went wrong\n" +
+				"FAIL\n",
+			wantIn: []string{"testFailed", "details='something went wrong'"},
+		},
+		{
+			name: "skip",
+			input: "=== RUN   TestBaz\n" +
+				"--- SKIP: TestBaz (0.00s)\n" +
+				"ok  \tpkg/foo\t0.000s [no tests to run]\n",
+			wantIn: []string{"testIgnored"},
+			wantNo: []string{"testFailed"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := runReader(t, tc.input, false)
+			for _, want := range tc.wantIn {
+				if !strings.Contains(out, want) {
+					t.Errorf("output missing %q, got:\n%s", want, out)
+				}
+			}
+			for _, no := range tc.wantNo {
+				if strings.Contains(out, no) {
+					t.Errorf("output unexpectedly contains %q, got:\n%s", no, out)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessReaderGocheck(t *testing.T) {
+	input := "START: foo_test.go:10: FooSuite.TestA\n" +
+		"PASS: foo_test.go:10: FooSuite.TestA\t0.001s\n" +
+		"START: foo_test.go:20: FooSuite.TestB\n" +
+		"some output line\n" +
+		"FAIL: foo_test.go:20: FooSuite.TestB\t0.002s\n"
+
+	out := runReader(t, input, false)
+	if !strings.Contains(out, "testSuiteStarted name='FooSuite'") {
+		t.Errorf("expected gocheck suite to be reported, got:\n%s", out)
+	}
+	if !strings.Contains(out, "name='TestA'") || !strings.Contains(out, "name='TestB'") {
+		t.Errorf("expected both gocheck tests to be reported, got:\n%s", out)
+	}
+	if !strings.Contains(out, "testFailed") {
+		t.Errorf("expected TestB to be reported as failed, got:\n%s", out)
+	}
+}
+
+func TestProcessReaderBuildFailedAndNoTestFiles(t *testing.T) {
+	input := "# pkg/foo\n" +
+		"pkg/foo/foo.go:5:2: undefined: bar\n" +
+		"FAIL\tpkg/foo [build failed]\n" +
+		"?   \tpkg/baz\t[no test files]\n"
+
+	out := runReader(t, input, false)
+	if !strings.Contains(out, "name='pkg/foo'") || !strings.Contains(out, "testFailed") {
+		t.Errorf("expected pkg/foo to be reported as failed, got:\n%s", out)
+	}
+	if !strings.Contains(out, "details='# pkg/foo|npkg/foo/foo.go:5:2: undefined: bar'") {
+		t.Errorf("expected compiler output to be captured as failure details, got:\n%s", out)
+	}
+	if !strings.Contains(out, "name='pkg/baz'") || !strings.Contains(out, "testIgnored") {
+		t.Errorf("expected pkg/baz to be reported as ignored, got:\n%s", out)
+	}
+}
+
+func TestProcessReaderBench(t *testing.T) {
+	input := "BenchmarkFoo-8   \t 2000000\t       716 ns/op\t      32 B/op\t       1 allocs/op\n"
+
+	out := runReader(t, input, false)
+	for _, want := range []string{
+		"buildStatisticValue key='BenchmarkFoo-8.ns/op' value='716'",
+		"buildStatisticValue key='BenchmarkFoo-8.B/op' value='32'",
+		"buildStatisticValue key='BenchmarkFoo-8.allocs/op' value='1'",
+		"name='BenchmarkFoo-8'",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "testFailed") {
+		t.Errorf("benchmark result must not be reported as a failure, got:\n%s", out)
+	}
+}
+
+func TestProcessReaderTimestampPrefix(t *testing.T) {
+	input := "2026-07-25T10:00:00.000000 === RUN   TestFoo\n" +
+		"2026-07-25T10:00:00.100000 --- PASS: TestFoo (0.05s)\n" +
+		"2026-07-25T10:00:05.000000 ok  \tpkg/foo\t5.000s\n"
+
+	t.Run("with -timestamp, prefix is parsed and stripped", func(t *testing.T) {
+		out := runReader(t, input, true)
+		if !strings.Contains(out, "timestamp='2026-07-25T10:00:00.000'") {
+			t.Errorf("expected real start timestamp to be used, got:\n%s", out)
+		}
+	})
+
+	t.Run("without -timestamp, lines pass through unmodified", func(t *testing.T) {
+		out := runReader(t, input, false)
+		if !strings.Contains(out, "2026-07-25T10:00:00.000000 === RUN") {
+			t.Errorf("expected raw line with its timestamp prefix intact, got:\n%s", out)
+		}
+		if strings.Contains(out, "timestamp='2026-07-25T10:00:00.000'") {
+			t.Errorf("external prefix must not be consulted without -timestamp, got:\n%s", out)
+		}
+	})
+}
+
+func TestProcessReaderLogLineTimestampNotEaten(t *testing.T) {
+	// A test logging a line that happens to start with an ISO-ish timestamp
+	// (e.g. zap/logrus console output) must not have that prefix stripped
+	// unless -timestamp was explicitly requested.
+	input := "=== RUN   TestA\n" +
+		"2024-01-02T15:04:05.123 starting some background worker\n" +
+		"--- PASS: TestA (0.05s)\n" +
+		"ok  \tpkg/foo\t0.05s\n"
+
+	out := runReader(t, input, false)
+	if !strings.Contains(out, "2024-01-02T15:04:05.123 starting some background worker") {
+		t.Errorf("expected log line's own timestamp to survive untouched, got:\n%s", out)
+	}
+}
+
+func TestProcessJSONPassFail(t *testing.T) {
+	input := `{"Time":"2026-07-25T10:00:00Z","Action":"run","Package":"pkg/foo","Test":"TestA"}
+{"Time":"2026-07-25T10:00:00.2Z","Action":"pass","Package":"pkg/foo","Test":"TestA","Elapsed":0.2}
+{"Time":"2026-07-25T10:00:00.3Z","Action":"pass","Package":"pkg/foo"}
+`
+	out := runJSON(t, input)
+	if !strings.Contains(out, "testSuiteStarted name='pkg/foo'") || !strings.Contains(out, "testSuiteFinished name='pkg/foo'") {
+		t.Errorf("expected package to be reported as a suite, got:\n%s", out)
+	}
+	if !strings.Contains(out, "name='TestA'") || strings.Contains(out, "testFailed") {
+		t.Errorf("expected TestA to pass cleanly, got:\n%s", out)
+	}
+}
+
+func TestProcessJSONBenchmarkWithoutTerminalActionIsNotReported(t *testing.T) {
+	// `go test -json -bench=.` emits run + output events for a benchmark but
+	// no terminating pass/fail/skip action; it must not be reported as failed.
+	input := `{"Time":"2026-07-25T10:00:00Z","Action":"run","Package":"pkg/foo","Test":"BenchmarkFoo"}
+{"Time":"2026-07-25T10:00:00.1Z","Action":"output","Package":"pkg/foo","Test":"BenchmarkFoo","Output":"BenchmarkFoo-8 \t 100 \t 10 ns/op\n"}
+{"Time":"2026-07-25T10:00:01Z","Action":"pass","Package":"pkg/foo"}
+`
+	out := runJSON(t, input)
+	if strings.Contains(out, "BenchmarkFoo") || strings.Contains(out, "testFailed") {
+		t.Errorf("unterminated benchmark entry must not be reported at all, got:\n%s", out)
+	}
+}
+
+func TestProcessJSONFailDoesNotDuplicateOutput(t *testing.T) {
+	input := `{"Time":"2026-07-25T10:00:00Z","Action":"run","Package":"pkg/foo","Test":"TestA"}
+{"Time":"2026-07-25T10:00:00.1Z","Action":"output","Package":"pkg/foo","Test":"TestA","Output":"some failure log\n"}
+{"Time":"2026-07-25T10:00:00.2Z","Action":"fail","Package":"pkg/foo","Test":"TestA","Elapsed":0.2}
+{"Time":"2026-07-25T10:00:00.3Z","Action":"fail","Package":"pkg/foo"}
+`
+	out := runJSON(t, input)
+	if n := strings.Count(out, "some failure log"); n != 1 {
+		t.Errorf("expected failure output to appear exactly once, got %d occurrences:\n%s", n, out)
+	}
+}
+
+func TestProcessJSONSurvivesBuildFailurePlainTextBeforeLaterPackage(t *testing.T) {
+	// `go test -json ./badpkg/... ./goodpkg/...` prints a broken package's
+	// build failure as plain, non-JSON text ahead of the JSON stream for the
+	// packages after it, even though -json was passed. A decode error on
+	// that text must not stop goodpkg's results from being reported too.
+	input := "# badpkg\n" +
+		"badpkg/foo.go:5:2: undefined: bar\n" +
+		"FAIL\tbadpkg [build failed]\n" +
+		`{"Time":"2026-07-25T10:00:00Z","Action":"run","Package":"goodpkg","Test":"TestA"}` + "\n" +
+		`{"Time":"2026-07-25T10:00:00.2Z","Action":"pass","Package":"goodpkg","Test":"TestA","Elapsed":0.2}` + "\n" +
+		`{"Time":"2026-07-25T10:00:00.3Z","Action":"pass","Package":"goodpkg"}` + "\n"
+
+	out := runJSON(t, input)
+	if !strings.Contains(out, "# badpkg") {
+		t.Errorf("expected the build-failure text to be forwarded, got:\n%s", out)
+	}
+	if !strings.Contains(out, "testSuiteStarted name='goodpkg'") || !strings.Contains(out, "name='TestA'") {
+		t.Errorf("expected goodpkg's results to still be reported, got:\n%s", out)
+	}
+}
+
+func TestJunitReporterOutput(t *testing.T) {
+	var buf bytes.Buffer
+	rep := &junitReporter{w: &buf}
+	now := time.Now()
+	rep.SuiteStart("pkg/foo")
+	rep.TestResult(&Test{Name: "TestA", Start: now.Format(TEAMCITY_TIMESTAMP_FORMAT), StartTime: now, Status: "PASS"}, "")
+	rep.TestResult(&Test{Name: "TestB", Start: now.Format(TEAMCITY_TIMESTAMP_FORMAT), StartTime: now, Status: "FAIL", Details: []string{"boom"}}, "")
+	rep.SuiteEnd("pkg/foo")
+	rep.Finalize()
+
+	out := buf.String()
+	for _, want := range []string{
+		"<testsuites>", "<testsuite", `name="TestA"`, `name="TestB"`, "<failure", "boom",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("JUnit output missing %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestStripTimestamp(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		wantLine  string
+		wantFound bool
+	}{
+		{"present", "2026-07-25T10:00:00.000000 hello\n", "hello\n", true},
+		{"absent", "hello\n", "hello\n", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, line := stripTimestamp(tc.line)
+			if got.IsZero() == tc.wantFound {
+				t.Errorf("stripTimestamp(%q) zero-time=%v, want found=%v", tc.line, got.IsZero(), tc.wantFound)
+			}
+			if line != tc.wantLine {
+				t.Errorf("stripTimestamp(%q) line = %q, want %q", tc.line, line, tc.wantLine)
+			}
+		})
+	}
+}
+
+func TestEscape(t *testing.T) {
+	got := escape("a|b\nc'd]e[f")
+	want := "a||b|nc|'d|]e|[f"
+	if got != want {
+		t.Errorf("escape() = %q, want %q", got, want)
+	}
+}